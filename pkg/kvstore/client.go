@@ -37,6 +37,14 @@ func initClient(module backendModule) error {
 		return err
 	}
 
+	// leaseMutex is held for the entire establish sequence, not just the
+	// individual assignments, so that a concurrent CloseClient() cannot
+	// observe defaultClient set while renewDefaultLease() is still in the
+	// middle of populating leaseInstance for it. Without this, CloseClient
+	// could null out and close a client that initClient is still setting up.
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+
 	defaultClient = c
 
 	deleteLegacyPrefixes()
@@ -50,5 +58,34 @@ func initClient(module backendModule) error {
 
 // Client returns the global kvstore client or nil if the client is not configured yet
 func Client() BackendOperations {
+	leaseMutex.RLock()
+	defer leaseMutex.RUnlock()
 	return defaultClient
 }
+
+// CloseClient closes the default kvstore client, if one is configured, and
+// tears down what initClient set up around it: it removes the registered
+// controllers, drops the reference to the default lease (the lease itself
+// is not revoked here), and closes the client's own connection. The
+// pointer is captured and cleared under leaseMutex before any of that
+// teardown runs, so a caller of Client() that races with CloseClient either
+// gets the old client in full working order or nil, never a client that is
+// still being torn down. The teardown itself happens outside the lock so a
+// slow backend Close() doesn't stall every Client() caller in the meantime.
+//
+// CloseClient is a no-op if no client is currently configured.
+func CloseClient() {
+	leaseMutex.Lock()
+	client := defaultClient
+	defaultClient = nil
+	leaseInstance = nil
+	leaseMutex.Unlock()
+
+	if client == nil {
+		return
+	}
+
+	kvstoreControllers.RemoveAll()
+
+	client.Close()
+}
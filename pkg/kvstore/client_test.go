@@ -0,0 +1,85 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"sync"
+	"testing"
+)
+
+// fakeBackend embeds BackendOperations so that it satisfies the interface
+// at compile time while only actually implementing the handful of methods
+// this test exercises; every other method panics if called, which is fine
+// since TestClientCloseClientRace never calls them.
+type fakeBackend struct {
+	BackendOperations
+}
+
+func (f *fakeBackend) Get(key string) ([]byte, error) {
+	return []byte("value"), nil
+}
+
+func (f *fakeBackend) Close() {}
+
+// TestClientCloseClientRace hammers Client() and CloseClient() from separate
+// goroutines under the race detector. It exists to guard the invariant
+// CloseClient was written to provide: a goroutine that calls Client() must
+// always see either a fully usable client or nil, never one that
+// CloseClient is in the middle of tearing down.
+func TestClientCloseClientRace(t *testing.T) {
+	var wg sync.WaitGroup
+
+	stop := make(chan struct{})
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if c := Client(); c != nil {
+					if _, err := c.Get("foo"); err != nil {
+						t.Errorf("Get on a client returned by Client() failed: %s", err)
+					}
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				CloseClient()
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		leaseMutex.Lock()
+		defaultClient = &fakeBackend{}
+		leaseInstance = struct{}{}
+		leaseMutex.Unlock()
+	}
+
+	close(stop)
+	wg.Wait()
+}
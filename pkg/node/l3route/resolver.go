@@ -0,0 +1,268 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package l3route resolves, for a given node and destination prefix,
+// whether traffic should take a direct route or go over a tunnel. Callers
+// feed it node and IP-pool updates as they see them and then ask it for the
+// reason behind a specific (node, prefix) pair, or for the full route table
+// at once. Keeping this table here rather than inline in pkg/node means it
+// has no dependency on netlink and can be exercised with plain Go values.
+package l3route
+
+import (
+	"net"
+	"sync"
+)
+
+// EncapMode describes how traffic destined to addresses in an IPPool should
+// be handled when the destination is not reachable by a direct route.
+type EncapMode int
+
+const (
+	// EncapNever means addresses in the pool are always reached by a direct
+	// route - the pool never requires tunneling.
+	EncapNever EncapMode = iota
+
+	// EncapCrossSubnet means addresses in the pool should be reached
+	// directly when possible and tunneled otherwise. The resolver itself
+	// has no way to tell whether a given remote node is actually L2
+	// adjacent - that requires a netlink route lookup - so it reports
+	// ReasonCrossSubnetTunnel for this mode and leaves the direct-vs-tunnel
+	// call to the caller applying the route.
+	EncapCrossSubnet
+
+	// EncapAlways means addresses in the pool are always reached via tunnel.
+	EncapAlways
+)
+
+// Reason tags why a particular route was chosen, so that an applier can map
+// it onto the correct link/nexthop without re-deriving the decision.
+type Reason string
+
+const (
+	// ReasonDirect means the owning pool never tunnels - the caller should
+	// install a direct route and nothing else.
+	ReasonDirect Reason = "direct"
+
+	// ReasonCrossSubnetTunnel means the owning pool prefers a direct route
+	// but falls back to tunneling. The caller is expected to attempt the
+	// direct route first and only fall back to the tunnel if that fails.
+	ReasonCrossSubnetTunnel Reason = "cross-subnet-tunnel"
+
+	// ReasonAlwaysTunnel means the owning pool is configured to always
+	// tunnel; the caller should not attempt a direct route at all.
+	ReasonAlwaysTunnel Reason = "always-tunnel"
+
+	// ReasonLocal means the prefix belongs to the local node and should be
+	// handled by the local allocator/host routes rather than a peer route.
+	ReasonLocal Reason = "local"
+)
+
+// IPPool describes a pod CIDR allocation pool and the encapsulation policy
+// that applies to addresses within it.
+type IPPool struct {
+	// CIDR is the pool's prefix
+	CIDR *net.IPNet
+
+	// NATOutgoing indicates that traffic leaving the pool towards
+	// destinations outside of the cluster is masqueraded. It does not
+	// affect routing decisions made by the resolver, but is carried through
+	// so appliers that also manage masquerading rules have a single source
+	// of truth for pool configuration.
+	NATOutgoing bool
+
+	// Encap selects how addresses in this pool are reached when they are
+	// not owned by the local node.
+	Encap EncapMode
+}
+
+// contains reports whether ip falls within the pool's CIDR.
+func (p IPPool) contains(ip net.IP) bool {
+	return p.CIDR != nil && p.CIDR.Contains(ip)
+}
+
+// NodeInfo is the resolver's view of a single cluster node.
+type NodeInfo struct {
+	// Name uniquely identifies the node
+	Name string
+
+	// Local marks this NodeInfo as describing the node the resolver is
+	// running on
+	Local bool
+
+	// PodCIDRs are the prefixes owned by this node
+	PodCIDRs []*net.IPNet
+
+	// UnderlayV4 and UnderlayV6 are the node's addresses in the underlay
+	// network, used as the via/tunnel-endpoint for routes towards its
+	// PodCIDRs of the matching family. A dual-stack node can have PodCIDRs
+	// of both families but only one of these set, e.g. a v6 pod CIDR
+	// reached over a v4-only underlay; Resolve leaves Via unset in that
+	// case rather than guessing a mismatched-family address.
+	UnderlayV4 net.IP
+	UnderlayV6 net.IP
+}
+
+// underlayFor returns the underlay address to use as Via for a route toward
+// prefix, chosen by prefix's own address family.
+func (n NodeInfo) underlayFor(prefix *net.IPNet) net.IP {
+	if prefix.IP.To4() != nil {
+		return n.UnderlayV4
+	}
+	return n.UnderlayV6
+}
+
+// Route is a single resolved route: install CIDR via Via, for the reason
+// given in Reason.
+type Route struct {
+	CIDR   *net.IPNet
+	Via    net.IP
+	Reason Reason
+	Node   string
+}
+
+// Resolver accumulates node and IP-pool state and resolves it into the
+// canonical set of routes on demand. It is safe for concurrent use.
+type Resolver struct {
+	mu    sync.Mutex
+	nodes map[string]NodeInfo
+	pools []IPPool
+}
+
+// NewResolver returns an empty Resolver ready to receive node and pool
+// updates.
+func NewResolver() *Resolver {
+	return &Resolver{
+		nodes: map[string]NodeInfo{},
+	}
+}
+
+// OnNodeUpdated adds or replaces the resolver's view of a node.
+func (r *Resolver) OnNodeUpdated(n NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[n.Name] = n
+}
+
+// OnNodeRemoved removes a node from the resolver's view, e.g. because it
+// left the cluster.
+func (r *Resolver) OnNodeRemoved(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, name)
+}
+
+// NodeNames returns the names of every node currently tracked by the
+// resolver. It lets a caller that drives OnNodeUpdated from its own
+// authoritative node list (e.g. syncClusterRouting's cc.nodes) detect which
+// previously known nodes have disappeared and call OnNodeRemoved for them.
+func (r *Resolver) NodeNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.nodes))
+	for name := range r.nodes {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// OnIPPoolUpdated replaces the resolver's view of the full set of IP pools.
+// Pools are provided as a complete set rather than incrementally since the
+// encapsulation decision for a given prefix depends on which pool - if any -
+// contains it, and pools are expected to be few and to change rarely.
+func (r *Resolver) OnIPPoolUpdated(pools []IPPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pools = pools
+}
+
+// poolFor returns the IP pool containing prefix, if any.
+func (r *Resolver) poolFor(prefix *net.IPNet) (IPPool, bool) {
+	for _, p := range r.pools {
+		if p.contains(prefix.IP) {
+			return p, true
+		}
+	}
+
+	return IPPool{}, false
+}
+
+// reasonForPrefix returns the Reason dictated by whichever IP pool contains
+// prefix, or ReasonDirect if prefix isn't covered by any known pool -
+// matching the behavior of routing everything directly when no pool policy
+// applies.
+func (r *Resolver) reasonForPrefix(prefix *net.IPNet) Reason {
+	pool, ok := r.poolFor(prefix)
+	if !ok {
+		return ReasonDirect
+	}
+
+	switch pool.Encap {
+	case EncapAlways:
+		return ReasonAlwaysTunnel
+	case EncapCrossSubnet:
+		return ReasonCrossSubnetTunnel
+	default:
+		return ReasonDirect
+	}
+}
+
+// Resolve computes the canonical set of routes for all known nodes, given
+// the currently known IP pools.
+func (r *Resolver) Resolve() []Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := make([]Route, 0, len(r.nodes))
+
+	for _, n := range r.nodes {
+		if n.Local {
+			for _, prefix := range n.PodCIDRs {
+				routes = append(routes, Route{CIDR: prefix, Reason: ReasonLocal, Node: n.Name})
+			}
+			continue
+		}
+
+		for _, prefix := range n.PodCIDRs {
+			routes = append(routes, Route{
+				CIDR:   prefix,
+				Via:    n.underlayFor(prefix),
+				Reason: r.reasonForPrefix(prefix),
+				Node:   n.Name,
+			})
+		}
+	}
+
+	return routes
+}
+
+// RouteFor returns the resolved route for a single (node, prefix) pair, as
+// would appear in Resolve()'s output, without requiring the caller to scan
+// the whole table itself. It is the query path generateRouteForIP uses to
+// decide a single route.
+func (r *Resolver) RouteFor(nodeName string, prefix *net.IPNet) (Route, bool) {
+	if prefix == nil {
+		return Route{}, false
+	}
+
+	for _, rt := range r.Resolve() {
+		if rt.Node == nodeName && rt.CIDR != nil && rt.CIDR.String() == prefix.String() {
+			return rt, true
+		}
+	}
+
+	return Route{}, false
+}
@@ -0,0 +1,176 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package l3route
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %s", s, err)
+	}
+	return n
+}
+
+// TestResolveLocalNode verifies prefixes owned by the local node always
+// resolve with ReasonLocal and no Via, regardless of IP pool policy.
+func TestResolveLocalNode(t *testing.T) {
+	r := NewResolver()
+
+	localCIDR := mustParseCIDR(t, "10.0.1.0/24")
+	r.OnNodeUpdated(NodeInfo{Name: "local", Local: true, PodCIDRs: []*net.IPNet{localCIDR}})
+	r.OnIPPoolUpdated([]IPPool{{CIDR: localCIDR, Encap: EncapAlways}})
+
+	routes := r.Resolve()
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(routes))
+	}
+
+	if routes[0].Reason != ReasonLocal {
+		t.Errorf("expected ReasonLocal, got %s", routes[0].Reason)
+	}
+
+	if routes[0].Via != nil {
+		t.Errorf("expected no Via for a local route, got %s", routes[0].Via)
+	}
+}
+
+// TestReasonForPrefixByEncapMode covers the pool-to-reason mapping that
+// drives every remote-node decision.
+func TestReasonForPrefixByEncapMode(t *testing.T) {
+	prefix := mustParseCIDR(t, "10.0.2.0/24")
+
+	tests := []struct {
+		name string
+		pool []IPPool
+		want Reason
+	}{
+		{"no pool covers the prefix", nil, ReasonDirect},
+		{"EncapNever", []IPPool{{CIDR: prefix, Encap: EncapNever}}, ReasonDirect},
+		{"EncapCrossSubnet", []IPPool{{CIDR: prefix, Encap: EncapCrossSubnet}}, ReasonCrossSubnetTunnel},
+		{"EncapAlways", []IPPool{{CIDR: prefix, Encap: EncapAlways}}, ReasonAlwaysTunnel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResolver()
+			r.OnIPPoolUpdated(tt.pool)
+
+			if got := r.reasonForPrefix(prefix); got != tt.want {
+				t.Errorf("reasonForPrefix(%s) = %s, want %s", prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestResolveRemoteNodeDualStackVia verifies a dual-stack remote node's v4
+// and v6 PodCIDRs each resolve Via their matching-family underlay address,
+// rather than both being stamped with whichever family happened to be set
+// first.
+func TestResolveRemoteNodeDualStackVia(t *testing.T) {
+	r := NewResolver()
+
+	v4CIDR := mustParseCIDR(t, "10.0.3.0/24")
+	v6CIDR := mustParseCIDR(t, "fd00:10:0:3::/64")
+	v4Underlay := net.ParseIP("192.0.2.1")
+	v6Underlay := net.ParseIP("2001:db8::1")
+
+	r.OnNodeUpdated(NodeInfo{
+		Name:       "remote",
+		PodCIDRs:   []*net.IPNet{v4CIDR, v6CIDR},
+		UnderlayV4: v4Underlay,
+		UnderlayV6: v6Underlay,
+	})
+
+	routes := r.Resolve()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+
+	for _, rt := range routes {
+		switch rt.CIDR.String() {
+		case v4CIDR.String():
+			if !rt.Via.Equal(v4Underlay) {
+				t.Errorf("v4 CIDR got Via %s, want %s", rt.Via, v4Underlay)
+			}
+		case v6CIDR.String():
+			if !rt.Via.Equal(v6Underlay) {
+				t.Errorf("v6 CIDR got Via %s, want %s", rt.Via, v6Underlay)
+			}
+		default:
+			t.Errorf("unexpected CIDR %s in resolved routes", rt.CIDR)
+		}
+	}
+}
+
+// TestRouteForMatchesNodeAndPrefix verifies RouteFor only returns a route
+// for the exact (node, prefix) pair requested, and reports ok=false for
+// anything else.
+func TestRouteForMatchesNodeAndPrefix(t *testing.T) {
+	r := NewResolver()
+
+	cidrA := mustParseCIDR(t, "10.0.4.0/24")
+	cidrB := mustParseCIDR(t, "10.0.5.0/24")
+	underlay := net.ParseIP("192.0.2.2")
+
+	r.OnNodeUpdated(NodeInfo{Name: "node-a", PodCIDRs: []*net.IPNet{cidrA}, UnderlayV4: underlay})
+	r.OnNodeUpdated(NodeInfo{Name: "node-b", PodCIDRs: []*net.IPNet{cidrB}, UnderlayV4: underlay})
+
+	if _, ok := r.RouteFor("node-a", cidrB); ok {
+		t.Error("expected no route for (node-a, cidrB), which node-a does not own")
+	}
+
+	if _, ok := r.RouteFor("node-c", cidrA); ok {
+		t.Error("expected no route for an unknown node")
+	}
+
+	if _, ok := r.RouteFor("node-a", nil); ok {
+		t.Error("expected no route for a nil prefix")
+	}
+
+	rt, ok := r.RouteFor("node-a", cidrA)
+	if !ok {
+		t.Fatal("expected a route for (node-a, cidrA)")
+	}
+
+	if rt.Node != "node-a" || rt.CIDR.String() != cidrA.String() {
+		t.Errorf("RouteFor returned unexpected route: %+v", rt)
+	}
+}
+
+// TestNodeNamesAndOnNodeRemoved verifies a node removed via OnNodeRemoved no
+// longer appears in NodeNames.
+func TestNodeNamesAndOnNodeRemoved(t *testing.T) {
+	r := NewResolver()
+
+	r.OnNodeUpdated(NodeInfo{Name: "node-a"})
+	r.OnNodeUpdated(NodeInfo{Name: "node-b"})
+
+	names := r.NodeNames()
+	if len(names) != 2 {
+		t.Fatalf("expected 2 node names, got %d: %v", len(names), names)
+	}
+
+	r.OnNodeRemoved("node-a")
+
+	names = r.NodeNames()
+	if len(names) != 1 || names[0] != "node-b" {
+		t.Fatalf("expected only node-b to remain, got %v", names)
+	}
+}
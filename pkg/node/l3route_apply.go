@@ -0,0 +1,123 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/pkg/node/l3route"
+)
+
+// routeResolver is the package-level l3route.Resolver that backs the
+// direct/tunnel decision in generateRouteForIP. refreshRouteResolver keeps
+// it in sync with cc.nodes and the cluster's IP pool configuration once per
+// syncClusterRouting cycle; generateRouteForIP queries it once per prefix.
+var routeResolver = l3route.NewResolver()
+
+// nodeInfo converts a Node into the NodeInfo shape consumed by l3route: its
+// per-family underlay addresses and the pod CIDRs it owns, which is
+// everything the resolver needs to tell a direct route from a tunneled one.
+func (n *Node) nodeInfo() l3route.NodeInfo {
+	info := l3route.NodeInfo{
+		Name:  n.Name,
+		Local: n.IsLocalNode(),
+	}
+
+	info.UnderlayV4 = n.GetIPv4()
+	info.UnderlayV6 = n.GetIPv6()
+
+	if cidr4 := n.GetIPv4AllocRange(); cidr4 != nil {
+		info.PodCIDRs = append(info.PodCIDRs, cidr4)
+	}
+
+	if cidr6 := n.GetIPv6AllocRange(); cidr6 != nil {
+		info.PodCIDRs = append(info.PodCIDRs, cidr6)
+	}
+
+	return info
+}
+
+// refreshRouteResolver feeds the current cluster node set and IP pool
+// configuration into routeResolver. Nodes that have left cc.nodes since the
+// last refresh are explicitly removed rather than left to accumulate.
+func (cc *clusterConfiguation) refreshRouteResolver() {
+	seen := make(map[string]bool, len(cc.nodes))
+
+	for _, n := range cc.nodes {
+		if n == nil {
+			continue
+		}
+
+		routeResolver.OnNodeUpdated(n.nodeInfo())
+		seen[n.Name] = true
+	}
+
+	for _, name := range routeResolver.NodeNames() {
+		if !seen[name] {
+			routeResolver.OnNodeRemoved(name)
+		}
+	}
+
+	routeResolver.OnIPPoolUpdated(buildIPPools(GetLocalNode()))
+}
+
+// buildIPPools derives the cluster's IP pools and their encapsulation policy
+// from the existing per-family direct routing and VXLAN routing knobs: a
+// family with both direct and VXLAN routing enabled gets cross-subnet
+// tunneling, a family with only VXLAN enabled always tunnels, and a family
+// with only direct routing enabled never tunnels. A family with neither
+// enabled gets no pool at all, so the resolver has no opinion on it and
+// generateRouteForIP falls through to the per-node/cluster-wide route as it
+// always has.
+func buildIPPools(localNode *Node) []l3route.IPPool {
+	if localNode == nil || localNode.Routing == nil {
+		return nil
+	}
+
+	var pools []l3route.IPPool
+
+	if pool := ipPoolFor(GetIPv4AllocRange(), localNode.Routing.DirectRouting, localNode.Routing.VXLANRouting, false); pool != nil {
+		pools = append(pools, *pool)
+	}
+
+	if pool := ipPoolFor(GetIPv6AllocRange(), localNode.Routing.DirectRouting, localNode.Routing.VXLANRouting, true); pool != nil {
+		pools = append(pools, *pool)
+	}
+
+	return pools
+}
+
+func ipPoolFor(prefix *net.IPNet, dr *directRoutingConfiguration, vr *vxlanRoutingConfiguration, ipv6 bool) *l3route.IPPool {
+	if prefix == nil {
+		return nil
+	}
+
+	direct := directRoutingEnabledForFamily(dr, ipv6)
+	vxlan := vr != nil && vr.Enabled
+
+	var encap l3route.EncapMode
+	switch {
+	case direct && vxlan:
+		encap = l3route.EncapCrossSubnet
+	case vxlan:
+		encap = l3route.EncapAlways
+	case direct:
+		encap = l3route.EncapNever
+	default:
+		return nil
+	}
+
+	return &l3route.IPPool{CIDR: prefix, Encap: encap}
+}
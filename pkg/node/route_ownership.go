@@ -0,0 +1,120 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/vishvananda/netlink"
+)
+
+// rtProtoCilium is the rtnetlink protocol value Cilium tags onto every route
+// it installs, whether on HostDevice, on VXLANDevice for VXLAN-backed routes,
+// or on the underlay device for direct routes. It lets syncClusterRouting
+// tell routes it owns apart from routes installed by anything else on the
+// host (the kernel itself, a DHCP client, a human). This package does not
+// register the value in /etc/iproute2/rt_protos.d, so `ip route` prints it
+// as a bare number.
+const rtProtoCilium = 200
+
+// installedRoutes tracks the destination prefixes that have been (re)installed
+// with rtProtoCilium, on whichever link they were installed on, during the
+// current syncClusterRouting cycle. It is reset at the start of every sync
+// and consulted at the end to garbage collect any Cilium-tagged route on
+// HostDevice or VXLANDevice that was not touched this cycle - for example
+// because the node that owned it left the cluster, an aux prefix was
+// removed, or routing mode changed from per-node to cluster-wide. The set is
+// keyed on prefix alone and not (prefix, link), and syncClusterRouting runs
+// gcStaleRoutes once for HostDevice and, when VXLAN routing is enabled, once
+// for VXLANDevice. Direct routes, which route.add() installs without a link
+// so that they land on the underlay device, are tagged and marked too but
+// are not garbage collected.
+//
+// A restart simply repopulates this set from scratch as each route is
+// replaced during the next sync, so a controller that restarts re-adopts
+// its previously installed routes (RouteReplace is idempotent) rather than
+// churning them.
+var installedRoutes = &routeOwnershipSet{prefixes: map[string]bool{}}
+
+type routeOwnershipSet struct {
+	mutex    lock.Mutex
+	prefixes map[string]bool
+}
+
+func (s *routeOwnershipSet) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prefixes = map[string]bool{}
+}
+
+func (s *routeOwnershipSet) mark(prefix *net.IPNet) {
+	if prefix == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prefixes[prefix.String()] = true
+}
+
+func (s *routeOwnershipSet) owns(prefix *net.IPNet) bool {
+	if prefix == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.prefixes[prefix.String()]
+}
+
+// gcStaleRoutes deletes every route on link tagged with rtProtoCilium whose
+// destination prefix was not (re)installed during the current
+// syncClusterRouting cycle, as recorded in installedRoutes.
+func gcStaleRoutes(link netlink.Link) error {
+	filter := &netlink.Route{Protocol: rtProtoCilium}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteListFiltered(family, filter, netlink.RT_FILTER_PROTOCOL)
+		if err != nil {
+			return fmt.Errorf("unable to list Cilium-owned routes: %s", err)
+		}
+
+		for _, rt := range routes {
+			if rt.LinkIndex != link.Attrs().Index {
+				continue
+			}
+
+			if installedRoutes.owns(rt.Dst) {
+				continue
+			}
+
+			rt := rt
+			scopedLog := log.WithField(logfields.Route, rt)
+
+			if err := netlink.RouteDel(&rt); err != nil {
+				scopedLog.WithError(err).Error("Unable to garbage collect stale route")
+				continue
+			}
+
+			scopedLog.Info("Garbage collected stale route no longer desired")
+		}
+	}
+
+	return nil
+}
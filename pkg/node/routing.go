@@ -19,6 +19,7 @@ import (
 	"net"
 
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node/l3route"
 
 	"github.com/vishvananda/netlink"
 )
@@ -46,7 +47,7 @@ func (r route) getNetlinkRoute() (netlink.Route, error) {
 	}
 
 	if r.link != "" {
-		link, err := netlink.LinkByName(HostDevice)
+		link, err := netlink.LinkByName(r.link)
 		if err != nil {
 			return netlink.Route{}, err
 		}
@@ -64,11 +65,14 @@ func (r route) add() error {
 	if err != nil {
 		return err
 	}
+	route.Protocol = rtProtoCilium
 
 	if err := netlink.RouteReplace(&route); err != nil {
 		return err
 	}
 
+	installedRoutes.mark(r.prefix)
+
 	log.WithField(logfields.Route, route).Debug("Installed route")
 
 	return nil
@@ -155,6 +159,7 @@ func replaceNexthopRoute(link netlink.Link, routerNet *net.IPNet) error {
 		LinkIndex: link.Attrs().Index,
 		Dst:       routerNet,
 		Scope:     netlink.SCOPE_LINK,
+		Protocol:  rtProtoCilium,
 	}
 
 	if findRoute(link, route) == nil {
@@ -168,6 +173,8 @@ func replaceNexthopRoute(link netlink.Link, routerNet *net.IPNet) error {
 		scopedLog.Debug("Added L2 nexthop route")
 	}
 
+	installedRoutes.mark(routerNet)
+
 	return nil
 }
 
@@ -201,13 +208,14 @@ func replaceNodeRoute(ip *net.IPNet) {
 		log.WithError(err).Error("Unable to add nexthop route")
 	}
 
-	route := netlink.Route{LinkIndex: link.Attrs().Index, Dst: ip, Gw: via, Src: local}
+	route := netlink.Route{LinkIndex: link.Attrs().Index, Dst: ip, Gw: via, Src: local, Protocol: rtProtoCilium}
 	scopedLog := log.WithField(logfields.Route, route)
 
 	if err := netlink.RouteReplace(&route); err != nil {
 		scopedLog.WithError(err).Error("Unable to add node route")
 	} else {
 		scopedLog.Debug("Replaced node route")
+		installedRoutes.mark(ip)
 	}
 }
 
@@ -232,20 +240,78 @@ func validateDirectL2Route(nodeIP net.IP) error {
 	return nil
 }
 
+// directRoutingConfiguration holds the direct routing knobs for a single
+// family pair. IPv4 and IPv6 are tracked independently so that a cluster
+// whose underlay only provides L2 adjacency for one address family can still
+// take advantage of direct routes for that family while the other family
+// falls back to per-node routes via HostDevice.
+type directRoutingConfiguration struct {
+	// InstallRoutesV4 enables direct routing for IPv4 node and pod CIDRs
+	InstallRoutesV4 bool
+
+	// InstallRoutesV6 enables direct routing for IPv6 node and pod CIDRs
+	InstallRoutesV6 bool
+}
+
+// directRoutingEnabledForFamily is the ipv6-bool-keyed core of
+// installRoutesForFamily, split out so that callers that already know the
+// family (e.g. when building an l3route.IPPool for a whole CIDR) don't have
+// to synthesize a representative IP just to ask the question.
+func directRoutingEnabledForFamily(dr *directRoutingConfiguration, ipv6 bool) bool {
+	if dr == nil {
+		return false
+	}
+
+	if ipv6 {
+		return dr.InstallRoutesV6
+	}
+
+	return dr.InstallRoutesV4
+}
+
+// installRoutesForFamily reports whether direct L2 routes should be attempted
+// for the given address family, based on the per-family direct routing
+// knobs. IPv4 and IPv6 are configured independently so that, for example, a
+// cluster with a routed IPv4 underlay but an unrouted IPv6 underlay can still
+// use direct routes for v4 while falling back to per-node routes for v6.
+func installRoutesForFamily(dr *directRoutingConfiguration, nodeIP net.IP) bool {
+	return directRoutingEnabledForFamily(dr, nodeIP.To4() == nil)
+}
+
 func (n *Node) generateRouteForIP(route *route, nodeIP, routerIP, sourceIP net.IP) {
 	localNode := GetLocalNode()
 
-	// Only consider automatic direct routes if mode is enabled, the node
-	// in question is not the local node and an IP of the node is known for
-	// the address family in question
-	if localNode.Routing != nil && localNode.Routing.DirectRouting.InstallRoutes && !n.IsLocalNode() {
-		err := validateDirectL2Route(nodeIP)
-		if err == nil {
-			route.via = nodeIP
-			return
+	if !n.IsLocalNode() && localNode.Routing != nil {
+		direct := installRoutesForFamily(localNode.Routing.DirectRouting, nodeIP)
+		vxlan := localNode.Routing.VXLANRouting != nil && localNode.Routing.VXLANRouting.Enabled
+
+		// routeResolver holds the l3route-resolved reason for this exact
+		// (node, prefix) pair, derived from the IP pool's encapsulation
+		// policy. It decides *whether* a direct or tunneled path applies at
+		// all; the host-local feasibility check (is the remote IP actually
+		// L2 adjacent?) still happens here, since that requires a netlink
+		// route lookup that has no business living in the pool-decision
+		// package.
+		if resolved, ok := routeResolver.RouteFor(n.Name, route.prefix); ok && (direct || vxlan) {
+			if direct && (resolved.Reason == l3route.ReasonDirect || resolved.Reason == l3route.ReasonCrossSubnetTunnel) {
+				if err := validateDirectL2Route(nodeIP); err == nil {
+					route.via = nodeIP
+					return
+				} else if resolved.Reason == l3route.ReasonDirect {
+					log.Warningf("Unable to use direct route to CIDR %s: %s", route.prefix, err)
+				}
+			}
+
+			if vxlan && (resolved.Reason == l3route.ReasonAlwaysTunnel || resolved.Reason == l3route.ReasonCrossSubnetTunnel) {
+				if remoteRouterIP := n.GetCiliumInternalIP(nodeIP.To4() == nil); remoteRouterIP != nil {
+					route.link = VXLANDevice
+					route.via = remoteRouterIP
+					return
+				}
+
+				log.Warningf("Unable to use VXLAN route to CIDR %s: router IP of node %s is not known", route.prefix, n.Name)
+			}
 		}
-
-		log.Warningf("Unable to use direct route to CIDR %s: %s", route.prefix, err)
 	}
 
 	if localNode.cluster.usePerNodeRoutes || n.IsLocalNode() {
@@ -284,6 +350,13 @@ func (cc *clusterConfiguation) syncClusterRouting() error {
 		return err
 	}
 
+	// Every route installed below gets recorded in installedRoutes so that
+	// the garbage collection pass at the end of this function can tell
+	// which Cilium-tagged routes are still wanted this cycle from ones left
+	// over by a node leaving, an aux prefix being removed, or routing mode
+	// changing from per-node to cluster-wide.
+	installedRoutes.reset()
+
 	routerNet4 := &net.IPNet{IP: GetInternalIPv4(), Mask: net.CIDRMask(32, 32)}
 	if err := replaceNexthopRoute(link, routerNet4); err != nil {
 		return err
@@ -294,6 +367,26 @@ func (cc *clusterConfiguation) syncClusterRouting() error {
 		return err
 	}
 
+	var vxlanLink netlink.Link
+
+	localNode := GetLocalNode()
+	if localNode.Routing != nil && localNode.Routing.VXLANRouting != nil && localNode.Routing.VXLANRouting.Enabled {
+		vxlanLink, err = ensureVXLANDevice(localNode.Routing.VXLANRouting.vni(), localNode.Routing.VXLANRouting.port())
+		if err != nil {
+			return err
+		}
+
+		if err := syncVXLANForwarding(cc, vxlanLink, localNode.Routing.VXLANRouting); err != nil {
+			return err
+		}
+	}
+
+	// Refresh the l3route resolver's view of the cluster before generating
+	// any routes this cycle, so that the direct/tunnel decision made below
+	// for each node reflects the current node set and IP pool policy rather
+	// than stale state from the previous sync.
+	cc.refreshRouteResolver()
+
 	for _, ns := range cc.nodes {
 		ns.synchronizeToDatapath()
 	}
@@ -307,5 +400,15 @@ func (cc *clusterConfiguation) syncClusterRouting() error {
 		replaceNodeRoute(prefix)
 	}
 
+	if err := gcStaleRoutes(link); err != nil {
+		log.WithError(err).Error("Unable to garbage collect stale Cilium routes")
+	}
+
+	if vxlanLink != nil {
+		if err := gcStaleRoutes(vxlanLink); err != nil {
+			log.WithError(err).Error("Unable to garbage collect stale Cilium VXLAN routes")
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
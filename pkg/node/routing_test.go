@@ -0,0 +1,82 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"net"
+	"testing"
+)
+
+// This file covers the pure per-family decision functions that
+// syncClusterRouting/generateRouteForIP call into, not a reconciliation
+// test exercising those methods themselves: both go through package-level
+// netlink calls (netlink.RouteReplace, netlink.LinkByName, ...) rather than
+// an injectable interface, and this package has no fake/mock netlink
+// harness to run them against without a real network namespace. Treat
+// these as unit coverage of the family-selection logic, one layer below
+// an actual mixed-family reconciliation run.
+
+// TestInstallRoutesForFamily covers the per-family direct routing decision
+// for a mixed-family cluster: nodes that only expose a v4 address, nodes
+// that only expose v6, and nodes that expose both, under every combination
+// of the InstallRoutesV4/InstallRoutesV6 knobs.
+func TestInstallRoutesForFamily(t *testing.T) {
+	v4 := net.ParseIP("192.0.2.10")
+	v6 := net.ParseIP("2001:db8::10")
+
+	tests := []struct {
+		name string
+		dr   *directRoutingConfiguration
+		ip   net.IP
+		want bool
+	}{
+		{"nil config, v4 node", nil, v4, false},
+		{"nil config, v6 node", nil, v6, false},
+		{"v4-only cluster, v4-only node", &directRoutingConfiguration{InstallRoutesV4: true}, v4, true},
+		{"v4-only cluster, v6-only node", &directRoutingConfiguration{InstallRoutesV4: true}, v6, false},
+		{"v6-only cluster, v4-only node", &directRoutingConfiguration{InstallRoutesV6: true}, v4, false},
+		{"v6-only cluster, v6-only node", &directRoutingConfiguration{InstallRoutesV6: true}, v6, true},
+		{"dual-stack cluster, v4 node", &directRoutingConfiguration{InstallRoutesV4: true, InstallRoutesV6: true}, v4, true},
+		{"dual-stack cluster, v6 node", &directRoutingConfiguration{InstallRoutesV4: true, InstallRoutesV6: true}, v6, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := installRoutesForFamily(tt.dr, tt.ip); got != tt.want {
+				t.Errorf("installRoutesForFamily(%+v, %s) = %v, want %v", tt.dr, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDirectRoutingEnabledForFamily exercises the same decision table via
+// the ipv6-bool entry point used when no representative IP is at hand (e.g.
+// when building an l3route.IPPool for a whole CIDR rather than for a single
+// node).
+func TestDirectRoutingEnabledForFamily(t *testing.T) {
+	mixed := &directRoutingConfiguration{InstallRoutesV4: true, InstallRoutesV6: false}
+
+	if !directRoutingEnabledForFamily(mixed, false) {
+		t.Error("expected IPv4 direct routing to be enabled for a v4-only-routed cluster")
+	}
+
+	if directRoutingEnabledForFamily(mixed, true) {
+		t.Error("expected IPv6 direct routing to be disabled for a v4-only-routed cluster")
+	}
+
+	if directRoutingEnabledForFamily(nil, false) || directRoutingEnabledForFamily(nil, true) {
+		t.Error("expected a nil directRoutingConfiguration to disable direct routing for both families")
+	}
+}
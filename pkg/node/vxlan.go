@@ -0,0 +1,291 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// VXLANDevice is the name of the VXLAN netlink link used for the
+	// encapsulation-based inter-node routing backend
+	VXLANDevice = "cilium_vxlan"
+
+	// DefaultVXLANPort is the default UDP destination port used for VXLAN
+	// encapsulated traffic when none is configured
+	DefaultVXLANPort = 8472
+
+	// DefaultVXLANVNI is the default VXLAN network identifier used when
+	// none is configured
+	DefaultVXLANVNI = 1
+)
+
+// vxlanRoutingConfiguration holds the cluster-wide VXLAN overlay settings.
+// It is the third routing mode alongside direct routing and per-node routing
+// via HostDevice: where the other two require either L2 adjacency or a
+// cluster-wide route through an external router, VXLANRouting lets nodes
+// that are separated by arbitrary L3 hops reach each other's pod CIDRs by
+// encapsulating traffic towards the remote node's underlay address.
+type vxlanRoutingConfiguration struct {
+	// Enabled switches the VXLAN overlay backend on. It may be enabled
+	// together with DirectRouting for a given address family, in which case
+	// direct routing takes precedence and the overlay is the fallback for
+	// nodes without L2 adjacency.
+	Enabled bool
+
+	// VNI is the VXLAN network identifier advertised to peers and used for
+	// all FDB/neigh entries and encapsulated packets
+	VNI int
+
+	// Port is the UDP destination port used for VXLAN encapsulated traffic
+	Port int
+}
+
+// vni returns the configured VNI or DefaultVXLANVNI if unset
+func (vr *vxlanRoutingConfiguration) vni() int {
+	if vr == nil || vr.VNI == 0 {
+		return DefaultVXLANVNI
+	}
+	return vr.VNI
+}
+
+// port returns the configured UDP port or DefaultVXLANPort if unset
+func (vr *vxlanRoutingConfiguration) port() int {
+	if vr == nil || vr.Port == 0 {
+		return DefaultVXLANPort
+	}
+	return vr.Port
+}
+
+// ensureVXLANDevice creates the cilium_vxlan link if it does not already
+// exist and brings it up. The link is created without a fixed remote so
+// that per-remote-node reachability is driven entirely by the FDB and
+// neighbor entries installed by syncVXLANForwarding.
+func ensureVXLANDevice(vni, port int) (netlink.Link, error) {
+	if link, err := netlink.LinkByName(VXLANDevice); err == nil {
+		return link, nil
+	}
+
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: netlink.LinkAttrs{Name: VXLANDevice},
+		VxlanId:   vni,
+		Port:      port,
+		Learning:  false,
+	}
+
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return nil, fmt.Errorf("unable to create VXLAN device %s: %s", VXLANDevice, err)
+	}
+
+	link, err := netlink.LinkByName(VXLANDevice)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup VXLAN device %s after creation: %s", VXLANDevice, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("unable to bring up VXLAN device %s: %s", VXLANDevice, err)
+	}
+
+	return link, nil
+}
+
+// remoteMAC is a fixed placeholder MAC used for FDB entries that forward by
+// underlay destination IP rather than by learned inner MAC. cilium_vxlan
+// never learns from the wire (Learning: false above), so every remote node
+// gets the same all-zero "catch all" MAC pointing at its underlay IP.
+var remoteMAC, _ = net.ParseMAC("00:00:00:00:00:00")
+
+// upsertVXLANForwarding installs a permanent FDB entry on the VXLAN device
+// that forwards traffic for remoteMAC to the given remote node's underlay IP.
+func upsertVXLANForwarding(link netlink.Link, underlayIP net.IP) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       netlink.FAMILY_BRIDGE,
+		State:        netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_SELF,
+		HardwareAddr: remoteMAC,
+		IP:           underlayIP,
+	}
+
+	if err := netlink.NeighAppend(neigh); err != nil {
+		return fmt.Errorf("unable to install VXLAN FDB entry for %s: %s", underlayIP, err)
+	}
+
+	log.WithField(logfields.IPAddr, underlayIP).Debug("Installed VXLAN FDB entry")
+
+	return nil
+}
+
+func deleteVXLANForwarding(link netlink.Link, underlayIP net.IP) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       netlink.FAMILY_BRIDGE,
+		Flags:        netlink.NTF_SELF,
+		HardwareAddr: remoteMAC,
+		IP:           underlayIP,
+	}
+
+	if err := netlink.NeighDel(neigh); err != nil {
+		return fmt.Errorf("unable to delete VXLAN FDB entry for %s: %s", underlayIP, err)
+	}
+
+	log.WithField(logfields.IPAddr, underlayIP).Debug("Deleted VXLAN FDB entry")
+
+	return nil
+}
+
+// upsertVXLANNeigh installs a permanent ARP/NDP neighbor entry resolving the
+// remote node's router IP to remoteMAC, so that routes via the VXLAN device
+// with that router IP as nexthop do not require ARP/NDP resolution over the
+// tunnel.
+func upsertVXLANNeigh(link netlink.Link, routerIP net.IP) error {
+	family := ipFamily(routerIP)
+
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		HardwareAddr: remoteMAC,
+		IP:           routerIP,
+	}
+
+	if err := netlink.NeighSet(neigh); err != nil {
+		return fmt.Errorf("unable to install VXLAN neighbor entry for %s: %s", routerIP, err)
+	}
+
+	log.WithField(logfields.IPAddr, routerIP).Debug("Installed VXLAN neighbor entry")
+
+	return nil
+}
+
+func deleteVXLANNeigh(link netlink.Link, routerIP net.IP) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       ipFamily(routerIP),
+		HardwareAddr: remoteMAC,
+		IP:           routerIP,
+	}
+
+	if err := netlink.NeighDel(neigh); err != nil {
+		return fmt.Errorf("unable to delete VXLAN neighbor entry for %s: %s", routerIP, err)
+	}
+
+	log.WithField(logfields.IPAddr, routerIP).Debug("Deleted VXLAN neighbor entry")
+
+	return nil
+}
+
+// vxlanConfigAgrees reports whether remote's advertised VNI/port - as seen
+// through the same per-node sync that already carries remote.Routing to us -
+// matches the VNI/port the local node is using for its own cilium_vxlan
+// device. A remote node that has not announced VXLAN routing at all is
+// assumed to be using the cluster default, same as a local nil config would.
+func vxlanConfigAgrees(local *vxlanRoutingConfiguration, remote *vxlanRoutingConfiguration) bool {
+	return local.vni() == remote.vni() && local.port() == remote.port()
+}
+
+// syncVXLANForwarding reconciles the FDB and neighbor entries on the VXLAN
+// device against cc.nodes: every remote node with a known underlay and
+// router IP gets an entry, and any entry left over from a node that is no
+// longer part of the cluster is removed. Remote nodes whose own announced
+// VNI/port disagree with ours are skipped and logged rather than given an
+// FDB/neighbor entry, since encapsulating towards them with a mismatched
+// VNI/port would silently blackhole rather than fail visibly.
+func syncVXLANForwarding(cc *clusterConfiguation, link netlink.Link, localVXLANRouting *vxlanRoutingConfiguration) error {
+	desiredUnderlay := map[string]bool{}
+	desiredRouter := map[string]bool{}
+
+	for _, n := range cc.nodes {
+		if n == nil || n.IsLocalNode() {
+			continue
+		}
+
+		var remoteVXLANRouting *vxlanRoutingConfiguration
+		if n.Routing != nil {
+			remoteVXLANRouting = n.Routing.VXLANRouting
+		}
+
+		if !vxlanConfigAgrees(localVXLANRouting, remoteVXLANRouting) {
+			log.WithField(logfields.NodeName, n.Name).Warningf(
+				"Node advertises VXLAN VNI %d port %d, which disagrees with our own VNI %d port %d; skipping VXLAN forwarding to this node",
+				remoteVXLANRouting.vni(), remoteVXLANRouting.port(), localVXLANRouting.vni(), localVXLANRouting.port())
+			continue
+		}
+
+		for _, pair := range []struct {
+			underlay net.IP
+			router   net.IP
+		}{
+			{n.GetIPv4(), n.GetCiliumInternalIP(false)},
+			{n.GetIPv6(), n.GetCiliumInternalIP(true)},
+		} {
+			if pair.underlay == nil || pair.router == nil {
+				continue
+			}
+
+			if err := upsertVXLANForwarding(link, pair.underlay); err != nil {
+				log.WithError(err).Warning("Unable to reconcile VXLAN FDB entry")
+				continue
+			}
+
+			if err := upsertVXLANNeigh(link, pair.router); err != nil {
+				log.WithError(err).Warning("Unable to reconcile VXLAN neighbor entry")
+				continue
+			}
+
+			desiredUnderlay[pair.underlay.String()] = true
+			desiredRouter[pair.router.String()] = true
+		}
+	}
+
+	fdbEntries, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_BRIDGE)
+	if err != nil {
+		return fmt.Errorf("unable to list VXLAN FDB entries: %s", err)
+	}
+
+	for _, neigh := range fdbEntries {
+		if desiredUnderlay[neigh.IP.String()] {
+			continue
+		}
+
+		if err := deleteVXLANForwarding(link, neigh.IP); err != nil {
+			log.WithError(err).Warning("Unable to garbage collect stale VXLAN FDB entry")
+		}
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		neighs, err := netlink.NeighList(link.Attrs().Index, family)
+		if err != nil {
+			return fmt.Errorf("unable to list VXLAN neighbor entries: %s", err)
+		}
+
+		for _, neigh := range neighs {
+			if desiredRouter[neigh.IP.String()] {
+				continue
+			}
+
+			if err := deleteVXLANNeigh(link, neigh.IP); err != nil {
+				log.WithError(err).Warning("Unable to garbage collect stale VXLAN neighbor entry")
+			}
+		}
+	}
+
+	return nil
+}